@@ -0,0 +1,323 @@
+package gomarkov
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+//Binary chain format: magic, version byte, order, then the state pool
+//as length-prefixed strings in index order, then the full-order
+//frequency matrix, then (version 2+) one (order, matrix) entry per
+//backoff order the Kneser-Ney smoothing in smoothing.go relies on. Each
+//matrix is one entry per non-empty row: currentIndex, pair count, and
+//that many (delta-encoded nextIndex, freq) pairs. JSON turns every int
+//key into a string and every sparse row into an object, which is the
+//bottleneck for chains trained on large corpora; this format is the
+//recommended persistence path for those. JSON marshaling is kept for
+//compatibility. Version 1 chains (written before backoffMat existed)
+//still decode, with an empty backoffMat.
+const (
+	binMagic      = "GMKV"
+	binVersion    = 2
+	binVersionMin = 1
+
+	//maxBinCount bounds any count read from the stream and used as a
+	//slice/map capacity hint (state count, matrix row count, row pair
+	//count) before it's corroborated by actually reading that many
+	//entries. Without a ceiling, a truncated or corrupted file can claim
+	//billions of entries and OOM the process before the first read past
+	//the count fails.
+	maxBinCount = 1 << 24
+	//maxBinStringLen bounds a single string's declared length the same
+	//way, for the same reason.
+	maxBinStringLen = 1 << 20
+)
+
+//WriteTo serializes the chain in gomarkov's binary format. It satisfies
+//io.WriterTo.
+func (chain *Chain) WriteTo(w io.Writer) (int64, error) {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	bw := &countingWriter{w: bufio.NewWriter(w)}
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	if err := bw.writeBytes([]byte(binMagic)); err != nil {
+		return bw.n, err
+	}
+	if err := bw.writeBytes([]byte{binVersion}); err != nil {
+		return bw.n, err
+	}
+	if err := bw.writeUvarint(buf, uint64(chain.Order)); err != nil {
+		return bw.n, err
+	}
+
+	stateCount := len(chain.statePool.intMap)
+	if err := bw.writeUvarint(buf, uint64(stateCount)); err != nil {
+		return bw.n, err
+	}
+	for i := 0; i < stateCount; i++ {
+		if err := bw.writeString(buf, chain.statePool.intMap[i]); err != nil {
+			return bw.n, err
+		}
+	}
+
+	if err := bw.writeMat(buf, chain.frequencyMat); err != nil {
+		return bw.n, err
+	}
+
+	backoffOrders := make([]int, 0, len(chain.backoffMat))
+	for order := range chain.backoffMat {
+		backoffOrders = append(backoffOrders, order)
+	}
+	sort.Ints(backoffOrders)
+	if err := bw.writeUvarint(buf, uint64(len(backoffOrders))); err != nil {
+		return bw.n, err
+	}
+	for _, order := range backoffOrders {
+		if err := bw.writeUvarint(buf, uint64(order)); err != nil {
+			return bw.n, err
+		}
+		if err := bw.writeMat(buf, chain.backoffMat[order]); err != nil {
+			return bw.n, err
+		}
+	}
+
+	if err := bw.w.(*bufio.Writer).Flush(); err != nil {
+		return bw.n, err
+	}
+	return bw.n, nil
+}
+
+//writeMat writes a frequency matrix as its row count followed by one
+//(currentIndex, pair count, delta-encoded (nextIndex, freq)...) entry
+//per non-empty row, in currentIndex order.
+func (bw *countingWriter) writeMat(buf []byte, mat map[int]sparseArray) error {
+	if err := bw.writeUvarint(buf, uint64(len(mat))); err != nil {
+		return err
+	}
+	currentIndexes := make([]int, 0, len(mat))
+	for idx := range mat {
+		currentIndexes = append(currentIndexes, idx)
+	}
+	sort.Ints(currentIndexes)
+	for _, currentIndex := range currentIndexes {
+		row := mat[currentIndex]
+		if err := bw.writeUvarint(buf, uint64(currentIndex)); err != nil {
+			return err
+		}
+		if err := bw.writeUvarint(buf, uint64(len(row))); err != nil {
+			return err
+		}
+		nextIndexes := make([]int, 0, len(row))
+		for idx := range row {
+			nextIndexes = append(nextIndexes, idx)
+		}
+		sort.Ints(nextIndexes)
+		prev := 0
+		for _, idx := range nextIndexes {
+			if err := bw.writeUvarint(buf, uint64(idx-prev)); err != nil {
+				return err
+			}
+			prev = idx
+			if err := bw.writeUvarint(buf, uint64(row[idx])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//ReadFrom decodes a chain previously written by WriteTo, replacing the
+//receiver's contents. It satisfies io.ReaderFrom.
+func (chain *Chain) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(binMagic))
+	if err := cr.readFull(magic); err != nil {
+		return cr.n, err
+	}
+	if string(magic) != binMagic {
+		return cr.n, fmt.Errorf("gomarkov: not a binary chain (bad magic)")
+	}
+	version := make([]byte, 1)
+	if err := cr.readFull(version); err != nil {
+		return cr.n, err
+	}
+	if version[0] < binVersionMin || version[0] > binVersion {
+		return cr.n, fmt.Errorf("gomarkov: unsupported binary chain version %d", version[0])
+	}
+
+	order, err := cr.readUvarint()
+	if err != nil {
+		return cr.n, err
+	}
+
+	stateCount, err := cr.readUvarint()
+	if err != nil {
+		return cr.n, err
+	}
+	if stateCount > maxBinCount {
+		return cr.n, fmt.Errorf("gomarkov: implausible state count %d in binary chain", stateCount)
+	}
+	stringMap := make(map[string]int, stateCount)
+	intMap := make(map[int]string, stateCount)
+	for i := uint64(0); i < stateCount; i++ {
+		s, err := cr.readString()
+		if err != nil {
+			return cr.n, err
+		}
+		stringMap[s] = int(i)
+		intMap[int(i)] = s
+	}
+
+	freqMat, err := cr.readMat()
+	if err != nil {
+		return cr.n, err
+	}
+
+	backoffMat := newBackoffMat(int(order))
+	if version[0] >= 2 {
+		backoffOrderCount, err := cr.readUvarint()
+		if err != nil {
+			return cr.n, err
+		}
+		for i := uint64(0); i < backoffOrderCount; i++ {
+			backoffOrder, err := cr.readUvarint()
+			if err != nil {
+				return cr.n, err
+			}
+			mat, err := cr.readMat()
+			if err != nil {
+				return cr.n, err
+			}
+			backoffMat[int(backoffOrder)] = mat
+		}
+	}
+
+	chain.Order = int(order)
+	chain.statePool = newSpool(stringMap, intMap)
+	chain.frequencyMat = freqMat
+	chain.backoffMat = backoffMat
+	chain.lock = new(sync.RWMutex)
+	chain.statsMu = new(sync.Mutex)
+	chain.stats = nil
+	return cr.n, nil
+}
+
+//readMat reads a matrix written by writeMat.
+func (cr *countingReader) readMat() (map[int]sparseArray, error) {
+	rowCount, err := cr.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if rowCount > maxBinCount {
+		return nil, fmt.Errorf("gomarkov: implausible row count %d in binary chain", rowCount)
+	}
+	mat := make(map[int]sparseArray, rowCount)
+	for i := uint64(0); i < rowCount; i++ {
+		currentIndex, err := cr.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		pairCount, err := cr.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if pairCount > maxBinCount {
+			return nil, fmt.Errorf("gomarkov: implausible pair count %d in binary chain", pairCount)
+		}
+		row := make(sparseArray, pairCount)
+		prev := 0
+		for p := uint64(0); p < pairCount; p++ {
+			delta, err := cr.readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			prev += int(delta)
+			freq, err := cr.readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			row[prev] = int(freq)
+		}
+		mat[int(currentIndex)] = row
+	}
+	return mat, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) writeBytes(b []byte) error {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return err
+}
+
+func (cw *countingWriter) writeUvarint(buf []byte, v uint64) error {
+	n := binary.PutUvarint(buf, v)
+	return cw.writeBytes(buf[:n])
+}
+
+func (cw *countingWriter) writeString(buf []byte, s string) error {
+	if err := cw.writeUvarint(buf, uint64(len(s))); err != nil {
+		return err
+	}
+	return cw.writeBytes([]byte(s))
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) readFull(b []byte) error {
+	n, err := io.ReadFull(cr.r, b)
+	cr.n += int64(n)
+	return err
+}
+
+func (cr *countingReader) readByte() (byte, error) {
+	if br, ok := cr.r.(io.ByteReader); ok {
+		b, err := br.ReadByte()
+		if err == nil {
+			cr.n++
+		}
+		return b, err
+	}
+	var b [1]byte
+	if err := cr.readFull(b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (cr *countingReader) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(byteReaderFunc(cr.readByte))
+}
+
+func (cr *countingReader) readString() (string, error) {
+	length, err := cr.readUvarint()
+	if err != nil {
+		return "", err
+	}
+	if length > maxBinStringLen {
+		return "", fmt.Errorf("gomarkov: implausible string length %d in binary chain", length)
+	}
+	b := make([]byte, length)
+	if err := cr.readFull(b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+type byteReaderFunc func() (byte, error)
+
+func (f byteReaderFunc) ReadByte() (byte, error) { return f() }
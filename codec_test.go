@@ -0,0 +1,64 @@
+package gomarkov
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	chain := NewChain(2)
+	chain.Add([]string{"the", "quick", "brown", "fox"})
+	chain.Add([]string{"the", "lazy", "dog"})
+
+	var buf bytes.Buffer
+	if _, err := chain.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	got := NewChain(1)
+	if _, err := got.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+
+	if got.Order != chain.Order {
+		t.Errorf("Order = %d, want %d", got.Order, chain.Order)
+	}
+	want, err := chain.TransitionProbability("fox", NGram{"quick", "brown"})
+	if err != nil {
+		t.Fatalf("TransitionProbability on original: %v", err)
+	}
+	gotP, err := got.TransitionProbability("fox", NGram{"quick", "brown"})
+	if err != nil {
+		t.Fatalf("TransitionProbability on round-tripped chain: %v", err)
+	}
+	if gotP != want {
+		t.Errorf("round-tripped TransitionProbability = %v, want %v", gotP, want)
+	}
+
+	// backoffMat must round-trip too, or LogProb on the decoded chain
+	// silently reverts to unsmoothed behavior.
+	wantLP := chain.LogProb(NGram{"quick", "brown"}, "fox")
+	gotLP := got.LogProb(NGram{"quick", "brown"}, "fox")
+	if gotLP != wantLP {
+		t.Errorf("round-tripped LogProb = %v, want %v", gotLP, wantLP)
+	}
+}
+
+func TestBinaryCodecRejectsImplausibleCounts(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(binMagic)
+	buf.WriteByte(binVersion)
+	varint := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(v uint64) {
+		n := binary.PutUvarint(varint, v)
+		buf.Write(varint[:n])
+	}
+	writeUvarint(1)       // order
+	writeUvarint(1 << 32) // stateCount: absurdly large, stream has no such data
+
+	got := NewChain(1)
+	if _, err := got.ReadFrom(&buf); err == nil {
+		t.Fatal("ReadFrom with an implausible state count should fail fast instead of allocating it")
+	}
+}
@@ -0,0 +1,79 @@
+package gomarkov
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+//GenerateConstrained draws a token like Generate, but only considers
+//candidates that satisfy pred, renormalizing the draw over that subset
+//of the current row's outgoing transitions. Like Generate, it backs off
+//to a shorter context when current was unseen at full order. If none of
+//the resulting row's candidates satisfy pred, it returns an error
+//rather than silently falling back to an unconstrained draw.
+func (chain *Chain) GenerateConstrained(current NGram, pred func(string) bool, rnd *rand.Rand) (string, error) {
+	if len(current) != chain.Order {
+		return "", errors.New("n-gram length does not match chain order")
+	}
+	if current[len(current)-1] == EndToken {
+		// Dont generate anything after the end token
+		return "", nil
+	}
+
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	row, exists := chain.rowForContext(current)
+	if !exists {
+		return "", fmt.Errorf("unknown ngram %v", current)
+	}
+
+	keys := make([]int, 0, len(row))
+	for idx := range row {
+		if pred(chain.statePool.intMap[idx]) {
+			keys = append(keys, idx)
+		}
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no transition from %v satisfies the constraint", current)
+	}
+	sort.Ints(keys)
+
+	sum := 0
+	for _, idx := range keys {
+		sum += row[idx]
+	}
+	var randN int
+	if rnd != nil {
+		randN = rnd.IntN(sum)
+	} else {
+		randN = rand.IntN(sum)
+	}
+	for _, idx := range keys {
+		randN -= row[idx]
+		if randN <= 0 {
+			return chain.statePool.intMap[idx], nil
+		}
+	}
+	return "", nil
+}
+
+//GlobPredicate returns a predicate matching tokens against a
+//path.Match-style glob pattern (*, ?, and [...] classes), e.g. "Fo*" to
+//require tokens starting with "Fo".
+func GlobPredicate(pattern string) func(string) bool {
+	return func(token string) bool {
+		ok, err := filepath.Match(pattern, token)
+		return err == nil && ok
+	}
+}
+
+//RegexpPredicate returns a predicate matching tokens against re, e.g.
+//for rhyme or suffix constraints that a glob can't express.
+func RegexpPredicate(re *regexp.Regexp) func(string) bool {
+	return re.MatchString
+}
@@ -0,0 +1,43 @@
+package gomarkov
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGenerateConstrainedGlob(t *testing.T) {
+	chain := NewChain(1)
+	chain.Add([]string{"start", "foo", "bar"})
+	chain.Add([]string{"start", "fizz", "bar"})
+
+	tok, err := chain.GenerateConstrained(NGram{"start"}, GlobPredicate("fo*"), nil)
+	if err != nil {
+		t.Fatalf("GenerateConstrained: %v", err)
+	}
+	if tok != "foo" {
+		t.Errorf("GenerateConstrained with glob %q = %q, want %q", "fo*", tok, "foo")
+	}
+}
+
+func TestGenerateConstrainedRegexp(t *testing.T) {
+	chain := NewChain(1)
+	chain.Add([]string{"start", "cat", "bar"})
+	chain.Add([]string{"start", "hat", "bar"})
+
+	tok, err := chain.GenerateConstrained(NGram{"start"}, RegexpPredicate(regexp.MustCompile("^c")), nil)
+	if err != nil {
+		t.Fatalf("GenerateConstrained: %v", err)
+	}
+	if tok != "cat" {
+		t.Errorf("GenerateConstrained with regexp ^c = %q, want %q", tok, "cat")
+	}
+}
+
+func TestGenerateConstrainedNoMatch(t *testing.T) {
+	chain := NewChain(1)
+	chain.Add([]string{"start", "cat", "bar"})
+
+	if _, err := chain.GenerateConstrained(NGram{"start"}, GlobPredicate("zzz*"), nil); err == nil {
+		t.Fatal("GenerateConstrained should error when no candidate satisfies the predicate")
+	}
+}
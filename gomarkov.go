@@ -1,14 +1,14 @@
 package gomarkov
 
 import (
+	"context"
+	cryptorand "crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"sort"
 	"sync"
-	"time"
-
-	"golang.org/x/exp/rand"
 )
 
 //Tokens are wrapped around a sequence of words to maintain the
@@ -18,20 +18,60 @@ const (
 	EndToken   = "^"
 )
 
-var lrnd = rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
+//ChainOption configures a Chain at construction time, or overrides that
+//configuration for a single Generate call.
+type ChainOption func(*chainOptions)
+
+type chainOptions struct {
+	rnd *rand.Rand
+}
+
+//WithRand overrides the source of randomness with rnd. Without it,
+//Generate draws from math/rand/v2's top-level functions, which are
+//already seeded from the OS CSPRNG and safe for concurrent use. Supply
+//a rnd built on a fixed seed (e.g. rand.NewChaCha8) to get reproducible
+//generation in tests.
+func WithRand(rnd *rand.Rand) ChainOption {
+	return func(o *chainOptions) {
+		o.rnd = rnd
+	}
+}
+
+func newRand() *rand.Rand {
+	var seed [32]byte
+	if _, err := cryptorand.Read(seed[:]); err != nil {
+		panic(err)
+	}
+	return rand.New(rand.NewChaCha8(seed))
+}
 
 //Chain is a markov chain instance
 type Chain struct {
 	Order        int
 	statePool    *spool
 	frequencyMat map[int]sparseArray
-	lock         *sync.RWMutex
+	//backoffMat holds the same transition counts as frequencyMat but
+	//keyed on shorter contexts, one matrix per order in [1, Order-1].
+	//It backs Kneser-Ney smoothing and Generate's back-off to a lower
+	//order when the full n-gram is unseen.
+	backoffMat map[int]map[int]sparseArray
+	lock       *sync.RWMutex
+	rnd        *rand.Rand
+
+	//statsMu guards stats, the per-order Kneser-Ney counts-of-counts
+	//cache built lazily by statsAt (smoothing.go) and invalidated by
+	//invalidateStats whenever Add/Merge/Prune change the counts it was
+	//computed from. It's a pointer for the same reason lock is: Chain
+	//has a value-receiver MarshalJSON, and copying a live mutex is unsafe.
+	statsMu *sync.Mutex
+	stats   map[int]*orderStats
 }
 
 type chainJSON struct {
-	Order    int                 `json:"int"`
-	SpoolMap map[string]int      `json:"spool_map"`
-	FreqMat  map[int]sparseArray `json:"freq_mat"`
+	Order      int                         `json:"int"`
+	SpoolMap   map[string]int              `json:"spool_map"`
+	FreqMat    map[int]sparseArray         `json:"freq_mat"`
+	BackoffMat map[int]map[int]sparseArray `json:"backoff_mat"`
 }
 
 //MarshalJSON ...
@@ -40,6 +80,7 @@ func (chain Chain) MarshalJSON() ([]byte, error) {
 		chain.Order,
 		chain.statePool.stringMap,
 		chain.frequencyMat,
+		chain.backoffMat,
 	}
 	return json.Marshal(obj)
 }
@@ -56,45 +97,93 @@ func (chain *Chain) UnmarshalJSON(b []byte) error {
 	for k, v := range obj.SpoolMap {
 		intMap[v] = k
 	}
-	chain.statePool = &spool{
-		stringMap: obj.SpoolMap,
-		intMap:    intMap,
-	}
+	chain.statePool = newSpool(obj.SpoolMap, intMap)
 	chain.frequencyMat = obj.FreqMat
+	if obj.BackoffMat != nil {
+		chain.backoffMat = obj.BackoffMat
+	} else {
+		// Chain was marshaled before backoffMat existed; smoothing and
+		// Generate's back-off degrade to having no lower-order data
+		// instead of failing to load.
+		chain.backoffMat = newBackoffMat(chain.Order)
+	}
 	chain.lock = new(sync.RWMutex)
+	chain.statsMu = new(sync.Mutex)
+	chain.stats = nil
 	return nil
 }
 
-//NewChain creates an instance of Chain
-func NewChain(order int) *Chain {
+//newBackoffMat allocates an empty backoffMat with one row map per order
+//in [1, order-1], the shape NewChain and Add expect to find already
+//there.
+func newBackoffMat(order int) map[int]map[int]sparseArray {
+	mat := make(map[int]map[int]sparseArray, order-1)
+	for k := 1; k < order; k++ {
+		mat[k] = make(map[int]sparseArray)
+	}
+	return mat
+}
+
+//NewChain creates an instance of Chain. By default it draws randomness
+//from math/rand/v2's global functions; pass WithRand to pin it to a
+//caller-supplied source instead.
+func NewChain(order int, opts ...ChainOption) *Chain {
 	chain := Chain{Order: order}
 	chain.statePool = &spool{
 		stringMap: make(map[string]int),
 		intMap:    make(map[int]string),
 	}
 	chain.frequencyMat = make(map[int]sparseArray)
+	chain.backoffMat = newBackoffMat(order)
 	chain.lock = new(sync.RWMutex)
+	chain.statsMu = new(sync.Mutex)
+	cfg := &chainOptions{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	chain.rnd = cfg.rnd
 	return &chain
 }
 
-//Add adds the transition counts to the chain for a given sequence of words
+//Add adds the transition counts to the chain for a given sequence of
+//words. Alongside the full-order transitions, it also tallies the same
+//sequence's transitions at every shorter context length, which feeds
+//LogProb/Perplexity's Kneser-Ney smoothing and lets Generate back off
+//to a lower order when a full n-gram is unseen. Each order is padded
+//with its own number of Start/EndToken copies rather than reusing the
+//full order's padding, so a backoff row matches what a standalone chain
+//of that order would have counted instead of picking up a spurious
+//StartToken->StartToken (and EndToken->EndToken) transition at the seam.
 func (chain *Chain) Add(input []string) {
-	startTokens := array(StartToken, chain.Order)
-	endTokens := array(EndToken, chain.Order)
-	tokens := make([]string, 0)
-	tokens = append(tokens, startTokens...)
+	chain.addPairs(chain.Order, padTokens(input, chain.Order), chain.frequencyMat)
+	for order, mat := range chain.backoffMat {
+		chain.addPairs(order, padTokens(input, order), mat)
+	}
+	chain.invalidateStats()
+}
+
+//padTokens wraps input with order copies of StartToken on the left and
+//EndToken on the right, the padding MakePairs needs to see the
+//sequence's own start and end as transitions at that order.
+func padTokens(input []string, order int) []string {
+	tokens := make([]string, 0, len(input)+2*order)
+	tokens = append(tokens, array(StartToken, order)...)
 	tokens = append(tokens, input...)
-	tokens = append(tokens, endTokens...)
-	pairs := MakePairs(tokens, chain.Order)
+	tokens = append(tokens, array(EndToken, order)...)
+	return tokens
+}
+
+func (chain *Chain) addPairs(order int, tokens []string, mat map[int]sparseArray) {
+	pairs := MakePairs(tokens, order)
 	for i := 0; i < len(pairs); i++ {
 		pair := pairs[i]
 		currentIndex := chain.statePool.add(pair.CurrentState.key())
 		nextIndex := chain.statePool.add(pair.NextState)
 		chain.lock.Lock()
-		if chain.frequencyMat[currentIndex] == nil {
-			chain.frequencyMat[currentIndex] = make(sparseArray)
+		if mat[currentIndex] == nil {
+			mat[currentIndex] = make(sparseArray)
 		}
-		chain.frequencyMat[currentIndex][nextIndex]++
+		mat[currentIndex][nextIndex]++
 		chain.lock.Unlock()
 	}
 }
@@ -104,6 +193,8 @@ func (chain *Chain) TransitionProbability(next string, current NGram) (float64,
 	if len(current) != chain.Order {
 		return 0, errors.New("n-gram length does not match chain order")
 	}
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
 	currentIndex, currentExists := chain.statePool.get(current.key())
 	nextIndex, nextExists := chain.statePool.get(next)
 	if !currentExists || !nextExists {
@@ -115,8 +206,19 @@ func (chain *Chain) TransitionProbability(next string, current NGram) (float64,
 	return freq / sum, nil
 }
 
-//Generate generates new text based on an initial seed of words
-func (chain *Chain) Generate(current NGram) (string, error) {
+//Generate generates new text based on an initial seed of words. It draws
+//from the chain's own rand.Rand (set via WithRand on NewChain) unless
+//opts supplies a per-call WithRand override, in which case that source
+//is used instead and the chain's default is left untouched. ctx is
+//checked before drawing so a caller can cancel a Generate embedded in a
+//larger loop. If the full n-gram was never seen during
+//training, Generate backs off to the longest shorter context that was,
+//rather than failing outright; only a context unseen even at order 1
+//returns an "unknown ngram" error.
+func (chain *Chain) Generate(ctx context.Context, current NGram, opts ...ChainOption) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	if len(current) != chain.Order {
 		return "", errors.New("n-gram length does not match chain order")
 	}
@@ -124,46 +226,33 @@ func (chain *Chain) Generate(current NGram) (string, error) {
 		// Dont generate anything after the end token
 		return "", nil
 	}
-	currentIndex, currentExists := chain.statePool.get(current.key())
-	if !currentExists {
-		return "", fmt.Errorf("unknown ngram %v", current)
-	}
-	arr := chain.frequencyMat[currentIndex]
-	sum := arr.sum()
-	randN := lrnd.Intn(sum)
-	for i, freq := range arr {
-		randN -= freq
-		if randN <= 0 {
-			return chain.statePool.intMap[i], nil
-		}
-	}
-	return "", nil
-}
 
-//Generate generates new text based on an initial seed of words
-func (chain *Chain) GenerateSeed(current NGram, rnd *rand.Rand) (string, error) {
-	if rnd == nil {
-		rnd = lrnd
-	}
-	if len(current) != chain.Order {
-		return "", errors.New("n-gram length does not match chain order")
+	cfg := &chainOptions{rnd: chain.rnd}
+	for _, opt := range opts {
+		opt(cfg)
 	}
-	if current[len(current)-1] == EndToken {
-		// Dont generate anything after the end token
-		return "", nil
-	}
-	currentIndex, currentExists := chain.statePool.get(current.key())
-	if !currentExists {
+	rnd := cfg.rnd
+
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	arr, exists := chain.rowForContext(current)
+	if !exists {
 		return "", fmt.Errorf("unknown ngram %v", current)
 	}
-	arr := chain.frequencyMat[currentIndex]
 	sum := arr.sum()
-	randN := rnd.Intn(sum)
 	keys := make([]int, 0, len(arr))
 	for k := range arr {
 		keys = append(keys, k)
 	}
 	sort.Ints(keys)
+
+	var randN int
+	if rnd != nil {
+		randN = rnd.IntN(sum)
+	} else {
+		randN = rand.IntN(sum)
+	}
 	for _, i := range keys {
 		randN -= arr[i]
 		if randN <= 0 {
@@ -172,3 +261,34 @@ func (chain *Chain) GenerateSeed(current NGram, rnd *rand.Rand) (string, error)
 	}
 	return "", nil
 }
+
+//rowForContext returns the outgoing-transition row for current, falling
+//back to progressively shorter suffixes of current (and their matching
+//backoffMat order) when longer contexts are unseen. Callers must hold
+//chain.lock for reading.
+func (chain *Chain) rowForContext(current NGram) (sparseArray, bool) {
+	ctx := current
+	mat := chain.matAt(len(ctx))
+	for {
+		if idx, ok := chain.statePool.get(ctx.key()); ok {
+			if row := mat[idx]; len(row) > 0 {
+				return row, true
+			}
+		}
+		if len(ctx) <= 1 {
+			return nil, false
+		}
+		ctx = ctx[1:]
+		mat = chain.matAt(len(ctx))
+	}
+}
+
+//matAt returns the frequency matrix for the given context order: the
+//chain's own frequencyMat at its native Order, or the matching
+//backoffMat entry for any shorter order.
+func (chain *Chain) matAt(order int) map[int]sparseArray {
+	if order == chain.Order {
+		return chain.frequencyMat
+	}
+	return chain.backoffMat[order]
+}
@@ -0,0 +1,76 @@
+package gomarkov
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestWithRandDeterministic(t *testing.T) {
+	newSeededChain := func() *Chain {
+		chain := NewChain(1, WithRand(rand.New(rand.NewPCG(1, 2))))
+		chain.Add([]string{"the", "quick", "brown", "fox"})
+		chain.Add([]string{"the", "lazy", "dog"})
+		return chain
+	}
+
+	first, err := newSeededChain().Generate(context.Background(), NGram{"the"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	second, err := newSeededChain().Generate(context.Background(), NGram{"the"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if first != second {
+		t.Fatalf("Generate with the same seeded rand.Rand produced %q then %q", first, second)
+	}
+}
+
+func TestTransitionProbability(t *testing.T) {
+	chain := NewChain(1)
+	chain.Add([]string{"a", "b"})
+	chain.Add([]string{"a", "b"})
+	chain.Add([]string{"a", "c"})
+
+	p, err := chain.TransitionProbability("b", NGram{"a"})
+	if err != nil {
+		t.Fatalf("TransitionProbability: %v", err)
+	}
+	if want := 2.0 / 3.0; p != want {
+		t.Errorf("TransitionProbability(b|a) = %v, want %v", p, want)
+	}
+
+	if p, err := chain.TransitionProbability("nope", NGram{"a"}); err != nil || p != 0 {
+		t.Errorf("TransitionProbability for an unseen next token = (%v, %v), want (0, nil)", p, err)
+	}
+}
+
+func TestAddBackoffRowsMatchStandaloneOrder(t *testing.T) {
+	chain := NewChain(2)
+	chain.Add([]string{"the", "quick", "brown"})
+
+	startIndex, ok := chain.statePool.get(StartToken)
+	if !ok {
+		t.Fatal("StartToken was never added to the state pool")
+	}
+	if freq := chain.backoffMat[1][startIndex][startIndex]; freq != 0 {
+		t.Errorf("backoffMat[1] has a StartToken->StartToken count of %d, want 0 (a standalone order-1 chain never counts one)", freq)
+	}
+
+	standalone := NewChain(1)
+	standalone.Add([]string{"the", "quick", "brown"})
+	want, err := standalone.TransitionProbability("the", NGram{StartToken})
+	if err != nil {
+		t.Fatalf("TransitionProbability on standalone order-1 chain: %v", err)
+	}
+
+	chain.lock.RLock()
+	row := chain.backoffMat[1][startIndex]
+	theIndex, _ := chain.statePool.get("the")
+	got := float64(row[theIndex]) / float64(row.sum())
+	chain.lock.RUnlock()
+	if got != want {
+		t.Errorf("backoffMat[1] StartToken->the probability = %v, want %v (matching a standalone order-1 chain)", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package gomarkov
+
+import "fmt"
+
+//Merge folds other's transition counts into chain, remapping other's
+//state indices through chain's own state pool. It's meant for
+//map-reduce style training: train per-shard chains on separate
+//goroutines over a corpus split, then Merge them into one. other is
+//left untouched; chain and other must share the same Order.
+func (chain *Chain) Merge(other *Chain) error {
+	if chain.Order != other.Order {
+		return fmt.Errorf("gomarkov: cannot merge order-%d chain into order-%d chain", other.Order, chain.Order)
+	}
+
+	other.lock.RLock()
+	defer other.lock.RUnlock()
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	remap := make(map[int]int, len(other.statePool.intMap))
+	for idx, s := range other.statePool.intMap {
+		remap[idx] = chain.statePool.add(s)
+	}
+
+	mergeMat(chain.frequencyMat, other.frequencyMat, remap)
+	for order, otherMat := range other.backoffMat {
+		dstMat := chain.backoffMat[order]
+		if dstMat == nil {
+			dstMat = make(map[int]sparseArray)
+			chain.backoffMat[order] = dstMat
+		}
+		mergeMat(dstMat, otherMat, remap)
+	}
+	chain.invalidateStats()
+	return nil
+}
+
+//mergeMat folds src's rows into dst, translating both the row key and
+//each row's transition targets through remap.
+func mergeMat(dst, src map[int]sparseArray, remap map[int]int) {
+	for otherIndex, row := range src {
+		currentIndex := remap[otherIndex]
+		dstRow := dst[currentIndex]
+		if dstRow == nil {
+			dstRow = make(sparseArray)
+			dst[currentIndex] = dstRow
+		}
+		for otherNext, freq := range row {
+			dstRow[remap[otherNext]] += freq
+		}
+	}
+}
+
+//Prune drops transitions with a frequency below minCount, from both the
+//full-order matrix and every backoff order, then garbage-collects any
+//state left with no remaining transitions in or out of it in any of
+//them. It trims the low-signal tail that otherwise dominates memory
+//after ingesting a large corpus.
+func (chain *Chain) Prune(minCount int) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	pruneMat(chain.frequencyMat, minCount)
+	for _, mat := range chain.backoffMat {
+		pruneMat(mat, minCount)
+	}
+
+	referenced := make(map[int]bool, len(chain.statePool.intMap))
+	mark := func(mat map[int]sparseArray) {
+		for currentIndex, row := range mat {
+			referenced[currentIndex] = true
+			for nextIndex := range row {
+				referenced[nextIndex] = true
+			}
+		}
+	}
+	mark(chain.frequencyMat)
+	for _, mat := range chain.backoffMat {
+		mark(mat)
+	}
+	for idx, s := range chain.statePool.intMap {
+		if !referenced[idx] {
+			delete(chain.statePool.intMap, idx)
+			delete(chain.statePool.stringMap, s)
+		}
+	}
+	chain.invalidateStats()
+}
+
+//pruneMat drops mat's transitions below minCount and deletes any row
+//left empty by doing so.
+func pruneMat(mat map[int]sparseArray, minCount int) {
+	for currentIndex, row := range mat {
+		for nextIndex, freq := range row {
+			if freq < minCount {
+				delete(row, nextIndex)
+			}
+		}
+		if len(row) == 0 {
+			delete(mat, currentIndex)
+		}
+	}
+}
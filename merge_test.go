@@ -0,0 +1,91 @@
+package gomarkov
+
+import "testing"
+
+func TestMergeEquivalentToSingleChain(t *testing.T) {
+	shards := [][]string{
+		{"the", "quick", "brown", "fox"},
+		{"the", "lazy", "dog", "sleeps"},
+		{"the", "quick", "dog", "runs"},
+	}
+
+	merged := NewChain(2)
+	for _, shard := range shards {
+		part := NewChain(2)
+		part.Add(shard)
+		if err := merged.Merge(part); err != nil {
+			t.Fatalf("Merge: %v", err)
+		}
+	}
+
+	single := NewChain(2)
+	for _, shard := range shards {
+		single.Add(shard)
+	}
+
+	cases := []struct {
+		current NGram
+		next    string
+	}{
+		{NGram{"$", "the"}, "quick"},
+		{NGram{"$", "the"}, "lazy"},
+		{NGram{"the", "quick"}, "brown"},
+		{NGram{"the", "quick"}, "dog"},
+		{NGram{"quick", "dog"}, "runs"},
+	}
+	for _, c := range cases {
+		want, err := single.TransitionProbability(c.next, c.current)
+		if err != nil {
+			t.Fatalf("TransitionProbability on single chain: %v", err)
+		}
+		got, err := merged.TransitionProbability(c.next, c.current)
+		if err != nil {
+			t.Fatalf("TransitionProbability on merged chain: %v", err)
+		}
+		if got != want {
+			t.Errorf("TransitionProbability(%q | %v): merged = %v, single-chain = %v", c.next, c.current, got, want)
+		}
+	}
+}
+
+func TestMergeOrderMismatch(t *testing.T) {
+	a := NewChain(1)
+	b := NewChain(2)
+	if err := a.Merge(b); err == nil {
+		t.Fatal("Merge across mismatched orders should return an error")
+	}
+}
+
+func TestAddAfterPruneDoesNotAliasIndices(t *testing.T) {
+	chain := NewChain(1)
+	chain.Add([]string{"common", "common", "common"})
+	chain.Add([]string{"rare", "rare"})
+	chain.Prune(2)
+
+	chain.Add([]string{"alpha", "beta", "gamma"})
+
+	for _, s := range []string{"alpha", "beta", "gamma", "common"} {
+		idx, ok := chain.statePool.get(s)
+		if !ok {
+			t.Fatalf("statePool.get(%q) = false, want true", s)
+		}
+		if got := chain.statePool.intMap[idx]; got != s {
+			t.Errorf("statePool.intMap[%d] = %q, want %q (index aliased with another state)", idx, got, s)
+		}
+	}
+}
+
+func TestPruneDropsLowFrequencyTransitionsAndOrphanStates(t *testing.T) {
+	chain := NewChain(1)
+	chain.Add([]string{"common", "common", "common"})
+	chain.Add([]string{"rare", "rare"})
+
+	chain.Prune(2)
+
+	if p, err := chain.TransitionProbability("common", NGram{"common"}); err != nil || p != 1 {
+		t.Errorf("TransitionProbability(common|common) after Prune = (%v, %v), want (1, nil)", p, err)
+	}
+	if _, exists := chain.statePool.get("rare"); exists {
+		t.Error("Prune should have garbage-collected the orphaned \"rare\" state")
+	}
+}
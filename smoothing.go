@@ -0,0 +1,169 @@
+package gomarkov
+
+import "math"
+
+//Scorer computes the log2 probability a chain assigns to a transition.
+//It exists so alternative smoothing strategies can be swapped in later;
+//KneserNey is the only implementation today.
+type Scorer interface {
+	LogProb(chain *Chain, current NGram, next string) float64
+}
+
+type kneserNeyScorer struct{}
+
+//KneserNey is an interpolated Kneser-Ney Scorer. It backs off through
+//the chain's lower-order transition counts (populated by Add alongside
+//the full-order ones) down to a continuation-probability estimate at
+//order 1, so it assigns a non-zero probability to n-grams that were
+//never observed during training as long as next was seen somewhere.
+var KneserNey Scorer = kneserNeyScorer{}
+
+func (kneserNeyScorer) LogProb(chain *Chain, current NGram, next string) float64 {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+	p := chain.knProb(current, next)
+	if p <= 0 {
+		return math.Inf(-1)
+	}
+	return math.Log2(p)
+}
+
+//LogProb returns the base-2 log probability KneserNey assigns to next
+//following current. Unlike TransitionProbability, it doesn't return 0
+//just because this exact n-gram was unseen; it only bottoms out at
+//-Inf if next was never observed during training at all.
+func (chain *Chain) LogProb(current NGram, next string) float64 {
+	return KneserNey.LogProb(chain, current, next)
+}
+
+//Perplexity scores how well the chain predicts tokens, a lower-is-better
+//measure commonly used to compare chains trained on the same corpus.
+func (chain *Chain) Perplexity(tokens []string) float64 {
+	padded := make([]string, 0, len(tokens)+2*chain.Order)
+	padded = append(padded, array(StartToken, chain.Order)...)
+	padded = append(padded, tokens...)
+	padded = append(padded, array(EndToken, chain.Order)...)
+
+	pairs := MakePairs(padded, chain.Order)
+	if len(pairs) == 0 {
+		return math.Inf(1)
+	}
+	var sumLog2 float64
+	for _, pair := range pairs {
+		sumLog2 += chain.LogProb(pair.CurrentState, pair.NextState)
+	}
+	return math.Exp2(-sumLog2 / float64(len(pairs)))
+}
+
+//knProb is the interpolated Kneser-Ney recursion. Callers must hold
+//chain.lock for reading.
+func (chain *Chain) knProb(context NGram, next string) float64 {
+	if len(context) == 0 {
+		return chain.continuationProb(1, next)
+	}
+
+	order := len(context)
+	mat := chain.matAt(order)
+	discount := chain.discount(order)
+
+	var countHW, countH, distinctNext float64
+	if idx, ok := chain.statePool.get(context.key()); ok {
+		row := mat[idx]
+		countH = float64(row.sum())
+		distinctNext = float64(len(row))
+		if nextIdx, ok := chain.statePool.get(next); ok {
+			countHW = float64(row[nextIdx])
+		}
+	}
+
+	lower := chain.knProb(context[1:], next)
+	if countH == 0 {
+		return lower
+	}
+	discounted := math.Max(countHW-discount, 0) / countH
+	backoffWeight := (discount / countH) * distinctNext
+	return discounted + backoffWeight*lower
+}
+
+//continuationProb is the Kneser-Ney base case: how many distinct
+//order-length contexts next completes (N1+(*w)), relative to the total
+//number of distinct (context, word) transition types at that order
+//(N1+(**)).
+func (chain *Chain) continuationProb(order int, next string) float64 {
+	nextIdx, ok := chain.statePool.get(next)
+	if !ok {
+		return 0
+	}
+	stats := chain.statsAt(order)
+	if stats.n1PlusDotDot == 0 {
+		return 0
+	}
+	return float64(stats.n1PlusDotW[nextIdx]) / float64(stats.n1PlusDotDot)
+}
+
+//discount is Kneser-Ney's absolute discount D = n1/(n1+2*n2), derived
+//from the counts-of-counts (how many transitions occurred exactly once,
+//exactly twice) at the given order.
+func (chain *Chain) discount(order int) float64 {
+	return chain.statsAt(order).discount
+}
+
+//orderStats is the per-order counts-of-counts that discount and
+//continuationProb both need: expensive to compute (a full scan of that
+//order's matrix) but unchanged until the chain is next mutated, so
+//statsAt caches one per order instead of every knProb recursion
+//rescanning the whole chain.
+type orderStats struct {
+	discount     float64
+	n1PlusDotW   map[int]int // nextIndex -> N1+(*w): distinct contexts reaching that word
+	n1PlusDotDot int         // N1+(**): distinct (context, word) transition types at this order
+}
+
+//statsAt returns the cached orderStats for order, computing and caching
+//it first if Add/Merge/Prune have invalidated the cache since the last
+//call.
+func (chain *Chain) statsAt(order int) *orderStats {
+	chain.statsMu.Lock()
+	defer chain.statsMu.Unlock()
+	if stats, ok := chain.stats[order]; ok {
+		return stats
+	}
+	stats := computeOrderStats(chain.matAt(order))
+	if chain.stats == nil {
+		chain.stats = make(map[int]*orderStats)
+	}
+	chain.stats[order] = stats
+	return stats
+}
+
+//invalidateStats drops the cached orderStats for every order, forcing
+//the next LogProb/Perplexity call to recompute them from the current
+//counts. Called by anything that mutates frequencyMat or backoffMat.
+func (chain *Chain) invalidateStats() {
+	chain.statsMu.Lock()
+	chain.stats = nil
+	chain.statsMu.Unlock()
+}
+
+func computeOrderStats(mat map[int]sparseArray) *orderStats {
+	stats := &orderStats{n1PlusDotW: make(map[int]int)}
+	var n1, n2 float64
+	for _, row := range mat {
+		stats.n1PlusDotDot += len(row)
+		for nextIdx, freq := range row {
+			stats.n1PlusDotW[nextIdx]++
+			switch freq {
+			case 1:
+				n1++
+			case 2:
+				n2++
+			}
+		}
+	}
+	if n1+2*n2 == 0 {
+		stats.discount = 0.75 // conventional default when counts-of-counts are degenerate
+	} else {
+		stats.discount = n1 / (n1 + 2*n2)
+	}
+	return stats
+}
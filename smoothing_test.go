@@ -0,0 +1,42 @@
+package gomarkov
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLogProbBacksOffToLowerOrder(t *testing.T) {
+	chain := NewChain(2)
+	chain.Add([]string{"the", "quick", "brown", "fox"})
+	chain.Add([]string{"a", "quick", "nap"})
+
+	// "brown quick" was never observed as a bigram context, but "quick"
+	// alone was seen transitioning to both "brown" and "nap", so
+	// Kneser-Ney should still assign it a non-zero (non -Inf) probability
+	// via back-off instead of returning -Inf like an unsmoothed model would.
+	lp := chain.LogProb(NGram{"brown", "quick"}, "nap")
+	if math.IsInf(lp, -1) {
+		t.Fatalf("LogProb for an unseen bigram context returned -Inf; want smoothed back-off")
+	}
+}
+
+func TestLogProbUnknownWordIsNegativeInfinity(t *testing.T) {
+	chain := NewChain(1)
+	chain.Add([]string{"hello", "world"})
+
+	lp := chain.LogProb(NGram{"hello"}, "neverseen")
+	if !math.IsInf(lp, -1) {
+		t.Errorf("LogProb for a next token never seen in training = %v, want -Inf", lp)
+	}
+}
+
+func TestPerplexityOfTrainingDataIsFinite(t *testing.T) {
+	chain := NewChain(1)
+	tokens := []string{"the", "quick", "brown", "fox"}
+	chain.Add(tokens)
+
+	pp := chain.Perplexity(tokens)
+	if math.IsInf(pp, 0) || math.IsNaN(pp) {
+		t.Errorf("Perplexity on the chain's own training data = %v, want a finite number", pp)
+	}
+}
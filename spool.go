@@ -0,0 +1,56 @@
+package gomarkov
+
+import "sync"
+
+// spool interns state strings to small integer indices so the chain's
+// sparse matrices can key on ints instead of repeating full n-gram
+// strings. nextIndex hands out indices independently of len(stringMap):
+// once Prune deletes entries, stringMap/intMap have gaps, and reusing
+// len(stringMap) as the next index would hand out one already owned by a
+// surviving state, aliasing two different tokens onto the same
+// frequencyMat/backoffMat row.
+type spool struct {
+	stringMap map[string]int
+	intMap    map[int]string
+	nextIndex int
+	sync.RWMutex
+}
+
+func (s *spool) add(str string) int {
+	s.RLock()
+	index, ok := s.stringMap[str]
+	s.RUnlock()
+	if ok {
+		return index
+	}
+	s.Lock()
+	defer s.Unlock()
+	index, ok = s.stringMap[str]
+	if ok {
+		return index
+	}
+	index = s.nextIndex
+	s.nextIndex++
+	s.stringMap[str] = index
+	s.intMap[index] = str
+	return index
+}
+
+func (s *spool) get(str string) (int, bool) {
+	index, ok := s.stringMap[str]
+	return index, ok
+}
+
+// newSpool builds a spool from a previously-persisted stringMap/intMap
+// pair (JSON or the binary codec), setting nextIndex past the highest
+// index either format handed out so a later add can't reissue one a
+// surviving state already occupies, gaps or not.
+func newSpool(stringMap map[string]int, intMap map[int]string) *spool {
+	next := 0
+	for idx := range intMap {
+		if idx+1 > next {
+			next = idx + 1
+		}
+	}
+	return &spool{stringMap: stringMap, intMap: intMap, nextIndex: next}
+}
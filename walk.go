@@ -0,0 +1,209 @@
+package gomarkov
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+//WalkOptions controls how Walk and WalkIter draw a sequence of tokens
+//from a Chain. The zero value draws unshaped, unbounded output that
+//only stops on EndToken.
+type WalkOptions struct {
+	//MaxTokens caps the number of tokens emitted, even if EndToken is
+	//never drawn. Zero means no cap.
+	MaxTokens int
+	//MinTokens keeps StopWords from ending the walk early; it cannot
+	//prevent an EndToken draw, since the chain may have no transitions
+	//to continue from once one is emitted.
+	MinTokens int
+	//StopWords ends the walk right after any of these tokens is
+	//emitted, in addition to EndToken. Ignored until MinTokens tokens
+	//have been emitted.
+	StopWords []string
+	//Temperature reshapes the sampling distribution before a draw:
+	//below 1 sharpens it toward the most likely tokens, above 1
+	//flattens it. Zero or one leaves frequencies unscaled.
+	Temperature float64
+	//TopK restricts sampling to the K most frequent candidates. Zero
+	//disables the restriction.
+	TopK int
+	//TopP restricts sampling to the smallest set of candidates whose
+	//cumulative weight reaches P, i.e. nucleus sampling. Zero disables
+	//the restriction.
+	TopP float64
+	//Rand overrides the chain's own source of randomness for this walk.
+	Rand *rand.Rand
+}
+
+//Walk generates a sequence of tokens starting from seed, which is
+//padded with StartToken on the left if shorter than the chain's order.
+//It stops on EndToken, on a configured StopWord, or once MaxTokens
+//tokens have been emitted.
+func (chain *Chain) Walk(seed []string, opts WalkOptions) ([]string, error) {
+	return chain.WalkContext(context.Background(), seed, opts)
+}
+
+//WalkContext is Walk with a context that can abort generation early;
+//ctx.Err() is returned if the walk is cancelled mid-stream.
+func (chain *Chain) WalkContext(ctx context.Context, seed []string, opts WalkOptions) ([]string, error) {
+	next, errFn := chain.WalkIter(ctx, seed, opts)
+	out := make([]string, 0, opts.MaxTokens)
+	for {
+		tok, ok := next()
+		if !ok {
+			break
+		}
+		out = append(out, tok)
+	}
+	return out, errFn()
+}
+
+//WalkIter is the streaming form of Walk. Each call to next draws the
+//next token and reports whether one was produced; once next returns
+//false, errFn reports why the walk stopped (nil for a clean stop on
+//EndToken, MaxTokens, or a StopWord).
+func (chain *Chain) WalkIter(ctx context.Context, seed []string, opts WalkOptions) (next func() (string, bool), errFn func() error) {
+	window := chain.seedWindow(seed)
+	stopWords := make(map[string]bool, len(opts.StopWords))
+	for _, w := range opts.StopWords {
+		stopWords[w] = true
+	}
+
+	var emitted int
+	var lastErr error
+	stopped := false
+
+	next = func() (string, bool) {
+		if stopped || lastErr != nil {
+			return "", false
+		}
+		if err := ctx.Err(); err != nil {
+			lastErr = err
+			return "", false
+		}
+		if opts.MaxTokens > 0 && emitted >= opts.MaxTokens {
+			stopped = true
+			return "", false
+		}
+
+		tok, err := chain.weightedDraw(window, opts)
+		if err != nil {
+			lastErr = err
+			return "", false
+		}
+		if tok == EndToken {
+			stopped = true
+			return "", false
+		}
+
+		shifted := make(NGram, chain.Order)
+		copy(shifted, window[1:])
+		shifted[chain.Order-1] = tok
+		window = shifted
+		emitted++
+
+		if emitted >= opts.MinTokens && stopWords[tok] {
+			stopped = true
+		}
+		return tok, true
+	}
+	errFn = func() error { return lastErr }
+	return next, errFn
+}
+
+//seedWindow builds the initial n-gram window for a walk, padding with
+//StartToken on the left when seed is shorter than the chain's order and
+//keeping only the trailing Order tokens when it's longer.
+func (chain *Chain) seedWindow(seed []string) NGram {
+	if len(seed) >= chain.Order {
+		window := make(NGram, chain.Order)
+		copy(window, seed[len(seed)-chain.Order:])
+		return window
+	}
+	window := make(NGram, 0, chain.Order)
+	window = append(window, array(StartToken, chain.Order-len(seed))...)
+	window = append(window, seed...)
+	return window
+}
+
+//weightedDraw samples one outgoing transition from window's row, after
+//applying opts' temperature scaling and top-k/top-p restriction. Like
+//Generate and GenerateConstrained, it falls back to the longest shorter
+//context that was seen during training via rowForContext instead of
+//failing outright when the full window is unseen. It holds chain.lock
+//for reading across the whole lookup and candidate scan, since both
+//read live chain state that Add can mutate concurrently.
+func (chain *Chain) weightedDraw(window NGram, opts WalkOptions) (string, error) {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	row, exists := chain.rowForContext(window)
+	if !exists {
+		return "", fmt.Errorf("unknown ngram %v", window)
+	}
+
+	type candidate struct {
+		idx    int
+		weight float64
+	}
+	cands := make([]candidate, 0, len(row))
+	for idx, freq := range row {
+		cands = append(cands, candidate{idx, float64(freq)})
+	}
+	if opts.Temperature > 0 && opts.Temperature != 1 {
+		for i := range cands {
+			cands[i].weight = math.Pow(cands[i].weight, 1/opts.Temperature)
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].weight != cands[j].weight {
+			return cands[i].weight > cands[j].weight
+		}
+		return cands[i].idx < cands[j].idx
+	})
+
+	if opts.TopK > 0 && opts.TopK < len(cands) {
+		cands = cands[:opts.TopK]
+	}
+	if opts.TopP > 0 && opts.TopP < 1 {
+		var total float64
+		for _, c := range cands {
+			total += c.weight
+		}
+		var cum float64
+		cut := len(cands)
+		for i, c := range cands {
+			cum += c.weight / total
+			if cum >= opts.TopP {
+				cut = i + 1
+				break
+			}
+		}
+		cands = cands[:cut]
+	}
+
+	var total float64
+	for _, c := range cands {
+		total += c.weight
+	}
+	rnd := opts.Rand
+	if rnd == nil {
+		rnd = chain.rnd
+	}
+	var r float64
+	if rnd != nil {
+		r = rnd.Float64() * total
+	} else {
+		r = rand.Float64() * total
+	}
+	for _, c := range cands {
+		r -= c.weight
+		if r <= 0 {
+			return chain.statePool.intMap[c.idx], nil
+		}
+	}
+	return chain.statePool.intMap[cands[len(cands)-1].idx], nil
+}
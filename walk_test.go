@@ -0,0 +1,68 @@
+package gomarkov
+
+import "testing"
+
+func TestWalkStopsOnEndToken(t *testing.T) {
+	chain := NewChain(1)
+	chain.Add([]string{"hello", "world"})
+
+	out, err := chain.Walk(nil, WalkOptions{MaxTokens: 50})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	want := []string{"hello", "world"}
+	if len(out) != len(want) {
+		t.Fatalf("Walk() = %v, want %v", out, want)
+	}
+	for i := range want {
+		if out[i] != want[i] {
+			t.Fatalf("Walk() = %v, want %v", out, want)
+		}
+	}
+}
+
+func TestWalkMaxTokens(t *testing.T) {
+	// Every token here occurs exactly once, so every row in the chain has
+	// a single possible transition and the walk is deterministic
+	// regardless of rand source.
+	chain := NewChain(1)
+	chain.Add([]string{"a", "b", "c", "d", "e"})
+
+	out, err := chain.Walk([]string{"a"}, WalkOptions{MaxTokens: 2})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if want := []string{"b", "c"}; len(out) != len(want) || out[0] != want[0] || out[1] != want[1] {
+		t.Fatalf("Walk() with MaxTokens=2 = %v, want %v", out, want)
+	}
+}
+
+func TestWalkBacksOffToLowerOrderContext(t *testing.T) {
+	// Order-2 chain: "brown quick" is never seen as a bigram context, but
+	// "quick" alone transitions to "brown" or "nap" at order 1, so Walk's
+	// draw should back off to that row instead of failing with "unknown ngram".
+	chain := NewChain(2)
+	chain.Add([]string{"the", "quick", "brown", "fox"})
+	chain.Add([]string{"a", "quick", "nap"})
+
+	tok, err := chain.weightedDraw(NGram{"brown", "quick"}, WalkOptions{})
+	if err != nil {
+		t.Fatalf("weightedDraw on an unseen bigram context: %v", err)
+	}
+	if tok != "nap" && tok != "brown" {
+		t.Errorf("weightedDraw backed off to an unexpected token %q", tok)
+	}
+}
+
+func TestWalkStopWords(t *testing.T) {
+	chain := NewChain(1)
+	chain.Add([]string{"a", "b", "c", "d"})
+
+	out, err := chain.Walk([]string{"a"}, WalkOptions{MaxTokens: 50, StopWords: []string{"c"}})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if len(out) == 0 || out[len(out)-1] != "c" {
+		t.Fatalf("Walk() with a StopWord = %v, want it to end on \"c\"", out)
+	}
+}